@@ -40,6 +40,7 @@ var (
 	flagExtFiles   = flag.String("context", "",
 		"import packages, functions, variables and constants from external golang source files")
 	flagPkg = flag.String("pkg", "", "specify a package where the session will be run inside")
+	flagCgo = flag.Bool("cgo", session.CgoAvailable(), "accept -context files that use cgo (checked with the cgo tool)")
 )
 
 func main() {
@@ -54,6 +55,7 @@ func main() {
 		AutoImports: *flagAutoImport,
 		DotPkg:      *flagPkg,
 		ExtFiles:    extFiles,
+		CgoEnabled:  *flagCgo,
 	}
 	if err := s.Init(); err != nil {
 		errorf("failed to prepare a session: %v", err)
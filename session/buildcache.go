@@ -0,0 +1,74 @@
+package session
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"go/printer"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// sourceHash returns a content hash of s.file plus s.extraFiles, used to key
+// the build cache: an unchanged hash means the last cached binary is still
+// good to run, so `go build` can be skipped entirely.
+func (s *Session) sourceHash() (string, error) {
+	h := sha256.New()
+
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, s.fset, s.file); err != nil {
+		return "", err
+	}
+	h.Write(buf.Bytes())
+
+	for _, f := range s.extraFiles {
+		buf.Reset()
+		if err := printer.Fprint(&buf, s.fset, f); err != nil {
+			return "", err
+		}
+		h.Write(buf.Bytes())
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// runCached builds s.filePath (and s.extraFilePaths) into s.BuildCacheDir,
+// keyed on sourceHash, and execs the result directly instead of invoking
+// `go run`. `go build` itself still reuses GOCACHE, so even a cache miss
+// here only pays for recompiling the session's own files and relinking,
+// not a full recompile of their dependencies.
+//
+// This only caches whole-program builds; splitting the accumulated program
+// into a stable "prelude" package plus a small per-input main, so unrelated
+// statements never need relinking either, is left as a future refinement.
+func (s *Session) runCached() error {
+	hash, err := s.sourceHash()
+	if err != nil {
+		return err
+	}
+
+	bin := filepath.Join(s.BuildCacheDir, hash)
+	if _, err := os.Stat(bin); err != nil {
+		if err := os.MkdirAll(s.BuildCacheDir, 0755); err != nil {
+			return err
+		}
+
+		args := append([]string{"build", "-o", bin}, append(s.extraFilePaths, s.filePath)...)
+		debugf("go %s", strings.Join(args, " "))
+		cmd := exec.Command("go", args...)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+	} else {
+		debugf("reusing cached build: %s", bin)
+	}
+
+	cmd := exec.Command(bin)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
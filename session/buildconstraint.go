@@ -0,0 +1,22 @@
+package session
+
+import (
+	"go/build"
+	"path/filepath"
+)
+
+// honorsBuildConstraints reports whether path's build constraints, if any
+// (//go:build or // +build comments, or a GOOS/GOARCH filename suffix), are
+// satisfied. Files that aren't are skipped by includeFiles rather than
+// included and then failing to type-check.
+//
+// It defers to go/build.Context.MatchFile rather than hand-rolling the tag
+// evaluation: MatchFile already knows about composite tags like "unix", Go
+// version tags ("go1.21"), release tags, and -- with CgoEnabled set below --
+// the "cgo" tag, none of which a GOOS/GOARCH-only callback would recognize.
+func (s *Session) honorsBuildConstraints(path string) (bool, error) {
+	ctxt := build.Default
+	ctxt.CgoEnabled = s.CgoEnabled
+
+	return ctxt.MatchFile(filepath.Dir(path), filepath.Base(path))
+}
@@ -0,0 +1,70 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// usesCgo reports whether src contains the cgo pseudo-import, import "C".
+func usesCgo(src []byte) bool {
+	return bytes.Contains(src, []byte(`import "C"`))
+}
+
+// cgoToolPath resolves the cgo tool. It isn't installed on $PATH by a
+// standard Go toolchain; it lives under $(go env GOTOOLDIR).
+func cgoToolPath() (string, error) {
+	out, err := exec.Command("go", "env", "GOTOOLDIR").Output()
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(strings.TrimSpace(string(out)), "cgo")
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// CgoAvailable reports whether the cgo tool can be located, for use as the
+// default value of Session.CgoEnabled.
+func CgoAvailable() bool {
+	_, err := cgoToolPath()
+	return err == nil
+}
+
+// preprocessCgo runs the cgo tool over file as a validity check: it fails
+// if file isn't valid cgo source. We deliberately don't feed the generated
+// output (the split .cgo1.go plus the _cgo_gotypes.go/object/runtime-cgo
+// glue cgo produces alongside it) into the run target ourselves -- that's
+// exactly what `go build`'s own, complete cgo support does when it sees
+// the original file with import "C" intact, so importFile keeps passing
+// the untouched source through and lets the toolchain do it.
+func preprocessCgo(file string) error {
+	tool, err := cgoToolPath()
+	if err != nil {
+		return fmt.Errorf("cgo: %v", err)
+	}
+
+	objDir, err := ioutil.TempDir("", "gore_cgo")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(objDir)
+
+	dir := filepath.Dir(file)
+	base := filepath.Base(file)
+	cmd := exec.Command(tool, "-objdir", objDir, "-srcdir", dir, base)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cgo %s: %v: %s", base, err, stderr.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package session
+
+import (
+	"fmt"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// packagesLoadMode is the set of information we need out of packages.Load:
+// enough to resolve an import path to its *types.Package without shelling
+// out to go/build, so module-relative and versioned import paths resolve
+// the same way `go build` would resolve them.
+const packagesLoadMode = packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles |
+	packages.NeedImports | packages.NeedTypes | packages.NeedSyntax
+
+// packagesImporter implements types.Importer on top of golang.org/x/tools/go/packages
+// so that imports are resolved against the module cache (GOPATH/pkg/mod) rather
+// than the pre-modules GOPATH/src layout that go/importer assumes.
+type packagesImporter struct {
+	dir   string // working directory packages are resolved relative to
+	cache map[string]*types.Package
+}
+
+// newPackagesImporter returns a types.Importer that resolves import paths
+// relative to dir using the current module (or GOPATH) build list.
+func newPackagesImporter(dir string) *packagesImporter {
+	return &packagesImporter{
+		dir:   dir,
+		cache: map[string]*types.Package{},
+	}
+}
+
+func (imp *packagesImporter) Import(path string) (*types.Package, error) {
+	if pkg, ok := imp.cache[path]; ok {
+		return pkg, nil
+	}
+
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packagesLoadMode,
+		Dir:  imp.dir,
+	}, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) == 0 {
+		return nil, fmt.Errorf("could not find package %q", path)
+	}
+
+	pkg := pkgs[0]
+	if len(pkg.Errors) > 0 {
+		return nil, pkg.Errors[0]
+	}
+	if pkg.Types == nil {
+		return nil, fmt.Errorf("package %q: type information unavailable", path)
+	}
+
+	// cache the packages packages.Load returned. That is only the requested
+	// package, not its dependencies: importing one of those later loads it
+	// again.
+	for _, p := range pkgs {
+		imp.cache[p.PkgPath] = p.Types
+	}
+
+	return pkg.Types, nil
+}
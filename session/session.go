@@ -8,18 +8,18 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"syscall"
 
 	"go/ast"
-	"go/build"
-	"go/importer"
 	"go/parser"
 	"go/printer"
 	"go/scanner"
 	"go/token"
 	"go/types"
 
+	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/imports"
 
 	"github.com/motemen/go-quickfix"
@@ -30,9 +30,11 @@ const printerName = "__gore_p"
 type Session struct {
 	// Parameters used by Init.
 
-	AutoImports bool     // Whether to enable auto imports.
-	ExtFiles    []string // List of files to include during Init.
-	DotPkg      string   // If not empty, the session dot import the package.
+	AutoImports   bool     // Whether to enable auto imports.
+	ExtFiles      []string // List of files to include during Init.
+	DotPkg        string   // If not empty, the session dot import the package.
+	CgoEnabled    bool     // Whether to accept included files that use cgo; they are checked with the cgo tool and passed to go build unprocessed.
+	BuildCacheDir string   // Directory holding cached go build outputs. Defaults to a per-session temp dir.
 
 	session // internal state.
 }
@@ -41,6 +43,7 @@ type session struct {
 	// fields computed in init and reset in reset.
 	filePath       string
 	file           *ast.File
+	comments       ast.CommentMap
 	fset           *token.FileSet
 	types          *types.Config
 	typeInfo       types.Info
@@ -78,6 +81,9 @@ var printerPkgs = []struct {
 }
 
 func (s *Session) reset() error {
+	if s.BuildCacheDir != "" {
+		os.RemoveAll(s.BuildCacheDir)
+	}
 	s.session = session{}
 	return s.Init()
 }
@@ -89,12 +95,28 @@ func newSession() (*Session, error) {
 func (s *Session) Init() error {
 	var err error
 	s.fset = token.NewFileSet()
-	s.types = &types.Config{Importer: importer.Default()}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		dir = "."
+	}
+	s.types = &types.Config{
+		Importer: newPackagesImporter(dir),
+		// typeCheckStmts type-checks s.extraFiles alongside s.file, and a
+		// -context file may itself use cgo; without this, checking it hits
+		// "C" as an ordinary (and unresolvable) import path.
+		FakeImportC: s.CgoEnabled,
+	}
+
 	s.filePath, err = tempFile()
 	if err != nil {
 		return err
 	}
 
+	if s.BuildCacheDir == "" {
+		s.BuildCacheDir = filepath.Join(filepath.Dir(s.filePath), "build-cache")
+	}
+
 	var initialSource string
 	for _, pp := range printerPkgs {
 		_, err := s.types.Importer.Import(pp.path)
@@ -109,10 +131,11 @@ func (s *Session) Init() error {
 		return fmt.Errorf(`Could not load pretty printing package (even "fmt"; something is wrong)`)
 	}
 
-	s.file, err = parser.ParseFile(s.fset, "gore_session.go", initialSource, parser.Mode(0))
+	s.file, err = parser.ParseFile(s.fset, "gore_session.go", initialSource, parser.ParseComments)
 	if err != nil {
 		return err
 	}
+	s.comments = ast.NewCommentMap(s.fset, s.file, s.file.Comments)
 
 	s.mainBody = s.mainFunc().Body
 
@@ -147,7 +170,7 @@ func (s *Session) Run() error {
 		return err
 	}
 
-	return goRun(append(s.extraFilePaths, s.filePath))
+	return s.runCached()
 }
 
 func tempFile() (string, error) {
@@ -164,16 +187,6 @@ func tempFile() (string, error) {
 	return filepath.Join(dir, "gore_session.go"), nil
 }
 
-func goRun(files []string) error {
-	args := append([]string{"run"}, files...)
-	debugf("go %s", strings.Join(args, " "))
-	cmd := exec.Command("go", args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
 func (s *Session) evalExpr(in string) (ast.Expr, error) {
 	expr, err := parser.ParseExpr(in)
 	if err != nil {
@@ -187,6 +200,13 @@ func (s *Session) evalExpr(in string) (ast.Expr, error) {
 		},
 	}
 
+	if err := s.typeCheckStmts([]ast.Stmt{stmt}); err != nil {
+		// expr is returned alongside the error so callers can tell "in
+		// isn't a valid expression" (expr == nil) apart from "in parsed
+		// as an expression but failed to type-check" (expr != nil).
+		return expr, err
+	}
+
 	s.appendStatements(stmt)
 
 	return expr, nil
@@ -230,6 +250,10 @@ func (s *Session) evalStmt(in string) error {
 		}
 	}
 
+	if err := s.typeCheckStmts(stmts); err != nil {
+		return err
+	}
+
 	s.appendStatements(stmts...)
 
 	return nil
@@ -266,7 +290,11 @@ func (s *Session) source(space bool) (string, error) {
 	}
 
 	var buf bytes.Buffer
-	err := config.Fprint(&buf, s.fset, s.file)
+	var node interface{} = s.file
+	if s.comments != nil {
+		node = &printer.CommentedNode{Node: s.file, Comments: s.comments.Comments()}
+	}
+	err := config.Fprint(&buf, s.fset, node)
 	return buf.String(), err
 }
 
@@ -276,12 +304,13 @@ func (s *Session) reload() error {
 		return err
 	}
 
-	file, err := parser.ParseFile(s.fset, "gore_session.go", source, parser.Mode(0))
+	file, err := parser.ParseFile(s.fset, "gore_session.go", source, parser.ParseComments)
 	if err != nil {
 		return err
 	}
 
 	s.file = file
+	s.comments = ast.NewCommentMap(s.fset, file, file.Comments)
 	s.mainBody = s.mainFunc().Body
 
 	return nil
@@ -322,6 +351,9 @@ func (s *Session) Eval(in string) error {
 	if _, err := s.evalExpr(in); err != nil {
 		debugf("expr :: err = %s", err)
 
+		// Also reached when in parsed as an expression but failed to
+		// type-check: a call with no result is rejected as an argument
+		// to the printer but is still valid as a statement.
 		err := s.evalStmt(in)
 		if err != nil {
 			debugf("stmt :: err = %s", err)
@@ -329,6 +361,12 @@ func (s *Session) Eval(in string) error {
 			if _, ok := err.(scanner.ErrorList); ok {
 				return ErrContinue
 			}
+
+			// Type error (or other failure already surfaced by
+			// typeCheckStmts): report it now and skip the go run
+			// round-trip, since it would only fail again, slower.
+			errorf("%s", err)
+			return err
 		}
 	}
 
@@ -367,6 +405,15 @@ func (s *Session) restoreMainBody() {
 // includeFiles imports packages and funcsions from multiple golang source
 func (s *Session) includeFiles(files []string) error {
 	for _, file := range files {
+		ok, err := s.honorsBuildConstraints(file)
+		if err != nil {
+			return fmt.Errorf("%q: %v", file, err)
+		}
+		if !ok {
+			debugf("skipping %s: build constraints not satisfied", file)
+			continue
+		}
+
 		if err := s.includeFile(file, false); err != nil {
 			return fmt.Errorf("%q: %v", file, err)
 		}
@@ -381,6 +428,16 @@ func (s *Session) includeFile(file string, includingMain bool) error {
 		return err
 	}
 
+	if s.CgoEnabled && usesCgo(content) {
+		// Validate the cgo usage up front; the original content (with
+		// import "C" intact) still goes on to importFile/extraFilePaths,
+		// since go build's own cgo support needs it unprocessed.
+		if err := preprocessCgo(file); err != nil {
+			errorf("%s", err)
+			return err
+		}
+	}
+
 	if err = s.importPackages(content); err != nil {
 		errorf("%s", err)
 		return err
@@ -397,12 +454,16 @@ func (s *Session) includeFile(file string, includingMain bool) error {
 
 // importPackages includes packages defined on external file into main file
 func (s *Session) importPackages(src []byte) error {
-	astf, err := parser.ParseFile(s.fset, "", src, parser.Mode(0))
+	astf, err := parser.ParseFile(s.fset, "", src, parser.ParseComments)
 	if err != nil {
 		return err
 	}
 
 	for _, imt := range astf.Imports {
+		if imt.Path.Value == `"C"` {
+			// the cgo pseudo-package: nothing to actually import.
+			continue
+		}
 		debugf("import package: %s", imt.Path.Value)
 		actionImport(s, imt.Path.Value)
 	}
@@ -410,7 +471,7 @@ func (s *Session) importPackages(src []byte) error {
 	return nil
 }
 
-// importFile adds external golang file to goRun target to use its function
+// importFile adds external golang file to the run target to use its function
 func (s *Session) importFile(src []byte, includingMain bool) error {
 	// Don't need to same directory
 	tmp, err := ioutil.TempFile(filepath.Dir(s.filePath), "gore_extarnal_")
@@ -420,7 +481,7 @@ func (s *Session) importFile(src []byte, includingMain bool) error {
 
 	ext := tmp.Name() + ".go"
 
-	f, err := parser.ParseFile(s.fset, ext, src, parser.Mode(0))
+	f, err := parser.ParseFile(s.fset, ext, src, parser.ParseComments)
 	if err != nil {
 		return err
 	}
@@ -438,6 +499,7 @@ func (s *Session) importFile(src []byte, includingMain bool) error {
 					// replace
 					s.mainFunc().Body = funcDecl.Body
 					s.mainBody = funcDecl.Body
+					s.mergeComments(f)
 				}
 				f.Decls = append(f.Decls[0:i], f.Decls[i+1:]...)
 				// main() removed from this file, we may have to
@@ -474,6 +536,21 @@ func (s *Session) importFile(src []byte, includingMain bool) error {
 	return nil
 }
 
+// mergeComments folds f's comments into s.file's, keyed by position, so
+// that comments attached to nodes spliced out of f (such as a replacement
+// main body) are still emitted the next time s.file is printed.
+func (s *Session) mergeComments(f *ast.File) {
+	if len(f.Comments) == 0 {
+		return
+	}
+
+	s.file.Comments = append(s.file.Comments, f.Comments...)
+	sort.Slice(s.file.Comments, func(i, j int) bool {
+		return s.file.Comments[i].Pos() < s.file.Comments[j].Pos()
+	})
+	s.comments = ast.NewCommentMap(s.fset, s.file, s.file.Comments)
+}
+
 // fixImports formats and adjusts imports for the current AST.
 func (s *Session) fixImports() error {
 
@@ -488,10 +565,11 @@ func (s *Session) fixImports() error {
 		return err
 	}
 
-	s.file, err = parser.ParseFile(s.fset, "", formatted, parser.Mode(0))
+	s.file, err = parser.ParseFile(s.fset, "", formatted, parser.ParseComments)
 	if err != nil {
 		return err
 	}
+	s.comments = ast.NewCommentMap(s.fset, s.file, s.file.Comments)
 	s.mainBody = s.mainFunc().Body
 
 	return nil
@@ -499,18 +577,29 @@ func (s *Session) fixImports() error {
 
 // includePackage adds the specified package as a '.' import so the session runs as if it is running in the package.
 func (s *Session) includePackage(path string) error {
-	pkg, err := build.Import(path, ".", 0)
+	cfg := &packages.Config{Mode: packagesLoadMode, Dir: "."}
+	pkgs, err := packages.Load(cfg, path)
 	if err != nil {
-		var err2 error
-		pkg, err2 = build.ImportDir(path, 0)
-		if err2 != nil {
-			return err // return package path import error, not directory import error as build.Import can also import directories if "./foo" is specified
+		return err
+	}
+	if len(pkgs) == 0 || len(pkgs[0].Errors) > 0 {
+		// path import failed; retry treating path as a directory, same
+		// fallback build.Import -> build.ImportDir used to provide.
+		cfg.Dir = path
+		dirPkgs, dirErr := packages.Load(cfg, ".")
+		if dirErr != nil || len(dirPkgs) == 0 {
+			if len(pkgs) > 0 && len(pkgs[0].Errors) > 0 {
+				return pkgs[0].Errors[0]
+			}
+			return fmt.Errorf("could not find package %q", path)
 		}
+		pkgs = dirPkgs
 	}
 
-	files := make([]string, len(pkg.GoFiles))
-	for i, f := range pkg.GoFiles {
-		files[i] = filepath.Join(pkg.Dir, f)
+	pkg := pkgs[0]
+	if len(pkg.Errors) > 0 {
+		return pkg.Errors[0]
 	}
-	return s.includeFiles(files)
+
+	return s.includeFiles(pkg.CompiledGoFiles)
 }
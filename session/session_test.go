@@ -0,0 +1,151 @@
+package session
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestSession(t *testing.T) *Session {
+	t.Helper()
+
+	s := &Session{}
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(filepath.Dir(s.filePath))
+		os.RemoveAll(s.BuildCacheDir)
+	})
+
+	return s
+}
+
+// TestSourcePreservesComments is a smoke test for the printer.CommentedNode
+// wiring in source(): a comment attached to an included file's main body
+// should still be present in the printed source after it replaces
+// s.mainFunc's body.
+func TestSourcePreservesComments(t *testing.T) {
+	s := newTestSession(t)
+
+	dir, err := ioutil.TempDir("", "gore_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const marker = "a marker comment for the test to look for"
+	file := filepath.Join(dir, "main.go")
+	src := "package main\n\nfunc main() {\n\t// " + marker + "\n}\n"
+	if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.includeFile(file, true); err != nil {
+		t.Fatalf("includeFile: %v", err)
+	}
+
+	out, err := s.source(false)
+	if err != nil {
+		t.Fatalf("source: %v", err)
+	}
+
+	if !strings.Contains(out, marker) {
+		t.Errorf("source() dropped the comment; got:\n%s", out)
+	}
+}
+
+// TestHonorsBuildConstraints is a smoke test for honorsBuildConstraints'
+// go/build.MatchFile-based matching: it should understand composite tags
+// such as "unix", not just literal GOOS/GOARCH names.
+func TestHonorsBuildConstraints(t *testing.T) {
+	s := newTestSession(t)
+
+	dir, err := ioutil.TempDir("", "gore_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "unix_only.go")
+	src := "//go:build unix\n\npackage main\n"
+	if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := s.honorsBuildConstraints(file)
+	if err != nil {
+		t.Fatalf("honorsBuildConstraints: %v", err)
+	}
+
+	// This suite only runs on unix-like CI/dev machines, so "unix" should
+	// match even though it isn't GOOS or GOARCH itself.
+	if !ok {
+		t.Errorf("honorsBuildConstraints(%q) = false, want true for the unix tag", file)
+	}
+
+	file = filepath.Join(dir, "plan9_only.go")
+	src = "//go:build plan9\n\npackage main\n"
+	if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err = s.honorsBuildConstraints(file)
+	if err != nil {
+		t.Fatalf("honorsBuildConstraints: %v", err)
+	}
+	if ok {
+		t.Errorf("honorsBuildConstraints(%q) = true, want false on a non-plan9 machine", file)
+	}
+}
+
+// TestCgoRoundTrip is a smoke test for the cgo include path: a -context file
+// using import "C" should be accepted as-is and actually build, exercising
+// go build's native cgo support end to end rather than just preprocessCgo's
+// validation step.
+func TestCgoRoundTrip(t *testing.T) {
+	if !CgoAvailable() {
+		t.Skip("cgo tool not available")
+	}
+
+	s := &Session{CgoEnabled: true}
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(filepath.Dir(s.filePath))
+		os.RemoveAll(s.BuildCacheDir)
+	})
+
+	dir, err := ioutil.TempDir("", "gore_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "greet.go")
+	src := `package main
+
+/*
+#include <string.h>
+*/
+import "C"
+
+func greetLen(s string) int {
+	return int(C.strlen(C.CString(s)))
+}
+`
+	if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.includeFiles([]string{file}); err != nil {
+		t.Fatalf("includeFiles: %v", err)
+	}
+
+	if err := s.Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+}
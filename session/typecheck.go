@@ -0,0 +1,67 @@
+package session
+
+import (
+	"go/ast"
+	"go/types"
+)
+
+// TypeCheck type-checks in as if it were the next statement or expression
+// evaluated, without mutating the session. It exists so editor integrations
+// can get the compile errors evalStmt/evalExpr report immediately, before the
+// slower go run round-trip that Run incurs.
+func (s *Session) TypeCheck(in string) error {
+	defer func(list []ast.Stmt, info types.Info) {
+		s.mainBody.List = list
+		s.typeInfo = info
+	}(s.mainBody.List, s.typeInfo)
+
+	if _, err := s.evalExpr(in); err == nil {
+		return nil
+	}
+
+	return s.evalStmt(in)
+}
+
+// typeCheckStmts type-checks stmts as if appended to the current main body,
+// leaving s.file untouched on failure. On success, s.typeInfo is updated so
+// subsequent code (e.g. completion, :doc) can rely on it.
+//
+// PARTIAL IMPLEMENTATION: this re-type-checks s.file and all of s.extraFiles
+// from scratch on every call; it does not do the delta-only check against a
+// cached types.Info that an incremental checker would. That's a conscious
+// gap, not an oversight: (*types.Checker).Files resets the checker's object
+// map on every call ("start with a clean slate (check.Files may be called
+// multiple times)", see go/types/check.go initFiles) and re-collects objects
+// for exactly the files it's given, so there's no supported way to hand it
+// only the newly appended statements and have it reuse type information it
+// already derived for the rest of main(). Doing real delta checking would
+// mean replacing types.Checker with a checker that tracks per-statement
+// dependency state itself -- out of scope here.
+//
+// What's shipped is still a net win over the pre-existing behavior, which
+// shelled out to go run for every statement and only learned of a type error
+// from its stderr: this catches the same errors synchronously, before that
+// round-trip. But it is not the incremental design asked for, and it does
+// not get cheaper as the session grows; flagging that explicitly here rather
+// than merging it as a fait accompli.
+func (s *Session) typeCheckStmts(stmts []ast.Stmt) error {
+	orig := s.mainBody.List
+	s.mainBody.List = append(append([]ast.Stmt{}, orig...), stmts...)
+	defer func() { s.mainBody.List = orig }()
+
+	info := types.Info{
+		Types: map[ast.Expr]types.TypeAndValue{},
+		Defs:  map[*ast.Ident]types.Object{},
+		Uses:  map[*ast.Ident]types.Object{},
+	}
+
+	files := append([]*ast.File{s.file}, s.extraFiles...)
+	checker := types.NewChecker(s.types, s.fset, types.NewPackage("", "main"), &info)
+	if err := checker.Files(files); err != nil {
+		return err
+	}
+
+	s.typeInfo = info
+
+	return nil
+}